@@ -0,0 +1,351 @@
+package config
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+)
+
+// FileLog configures wings' built-in log file writer. When enabled this
+// replaces the OS-level logrotate configuration written by EnableLogRotation
+// with an in-process writer that rotates its own output. This closes the gap
+// for systems that do not ship logrotate (Alpine, most containers) or for
+// wings installs that cannot write to /etc/logrotate.d.
+type FileLog struct {
+	// If set to true wings will write its own log file to Path and rotate it
+	// according to the settings below, rather than relying on the system
+	// logrotate configuration.
+	Enabled bool `default:"false" mapstructre:"enabled"`
+
+	// Path that the active log file is written to. If left blank this
+	// defaults to "wings.log" inside of the configured system log directory.
+	Path string `mapstructre:"path"`
+
+	// MaxSizeMiB is the size, in mebibytes, the active log file is allowed to
+	// reach before it is rotated out. Set to 0 to disable size based rotation.
+	MaxSizeMiB int64 `default:"10" mapstructre:"max_size_mib"`
+
+	// MaxAgeHours is the amount of time, in hours, the active log file is
+	// allowed to remain in use before it is rotated regardless of its size.
+	// Set to 0 to disable age based rotation.
+	MaxAgeHours int64 `default:"168" mapstructre:"max_age_hours"`
+
+	// MaxBackups is the number of rotated log files to keep on disk. Once
+	// this limit is exceeded the oldest rotated files are deleted.
+	MaxBackups int `default:"7" mapstructre:"max_backups"`
+
+	// Compress determines if a log file should be gzip compressed once it
+	// has been rotated out.
+	Compress bool `default:"true" mapstructre:"compress"`
+}
+
+// rotatingFileWriter is an io.WriteCloser that writes to a single log file on
+// disk, swapping it out for a new one once it exceeds the configured size or
+// age, and pruning backups past the configured limit. All writes and file
+// handle swaps are guarded by mu so that rotation is safe under concurrent
+// writers.
+type rotatingFileWriter struct {
+	mu     sync.Mutex
+	cfg    FileLog
+	f      *os.File
+	size   int64
+	opened time.Time
+	sig    chan os.Signal
+	done   chan struct{}
+	closed bool
+
+	// lastRotateAttempt is when rotate was last invoked, successfully or
+	// not. It throttles retries after a rename failure (e.g. a log
+	// directory on a different filesystem than its parent), where rotate
+	// otherwise returns nil having only reopened the same, still-oversized
+	// file: without this, shouldRotate would keep tripping on every single
+	// write and retry the failed rename/stat/prune sequence each time.
+	lastRotateAttempt time.Time
+}
+
+// rotateRetryBackoff is the minimum time between rotate attempts once one
+// has run, so a persistent rename failure degrades to a periodic retry
+// instead of being retried on every write.
+const rotateRetryBackoff = 30 * time.Second
+
+// NewFileLogWriter opens (or creates) the log file described by cfg and
+// returns a writer that transparently rotates it according to the FileLog
+// configuration. The writer also listens for SIGHUP so that external tools,
+// or an operator running "kill -SIGHUP", can force a rotation without
+// restarting wings, mirroring the postrotate hook used by EnableLogRotation.
+// Callers must call Close on the returned writer once it is no longer in
+// use, which stops the SIGHUP listener and releases the open file handle.
+func NewFileLogWriter(cfg FileLog) (io.WriteCloser, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("config: file_log.path must be set when file logging is enabled")
+	}
+
+	w := &rotatingFileWriter{cfg: cfg, sig: make(chan os.Signal, 1), done: make(chan struct{})}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	signal.Notify(w.sig, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-w.sig:
+				w.mu.Lock()
+				// Close may have run (and already closed w.f) between this
+				// case being selected and the lock being acquired, if a
+				// SIGHUP was already buffered on w.sig when Close was
+				// called. Bail out instead of reopening a file handle for a
+				// writer nobody holds a reference to anymore.
+				if w.closed {
+					w.mu.Unlock()
+					continue
+				}
+				err := w.rotate()
+				w.mu.Unlock()
+				if err != nil {
+					log.WithField("error", err).Error("config: failed to rotate wings log file on SIGHUP")
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *rotatingFileWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Path), 0755); err != nil {
+		return errors.WithMessage(err, "config: failed to create log directory")
+	}
+
+	f, err := os.OpenFile(w.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return errors.WithMessage(err, "config: failed to open log file")
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.WithMessage(err, "config: failed to stat log file")
+	}
+
+	opened, err := w.loadOrInitOpenedTime(st)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.f = f
+	w.size = st.Size()
+	w.opened = opened
+
+	return nil
+}
+
+// stateFile is where the active log file's true "first opened" time is
+// persisted, next to the log file itself.
+func (w *rotatingFileWriter) stateFile() string {
+	return w.cfg.Path + ".state"
+}
+
+// loadOrInitOpenedTime recovers the time the active log file was first
+// opened for writing. A freshly rotated or brand new file's mtime is a fine
+// stand-in for that, but an existing, non-empty file is reopened on every
+// wings restart with its mtime refreshed by each write, so trusting
+// st.ModTime() there would mean MaxAgeHours never trips for a log file that
+// is written to regularly across restarts. Instead the open time is
+// persisted to a small sidecar file next to the log file and reused across
+// restarts until the next rotation resets it.
+func (w *rotatingFileWriter) loadOrInitOpenedTime(st os.FileInfo) (time.Time, error) {
+	if st.Size() > 0 {
+		if b, err := ioutil.ReadFile(w.stateFile()); err == nil {
+			if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(b))); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	now := time.Now()
+	if err := ioutil.WriteFile(w.stateFile(), []byte(now.Format(time.RFC3339)), 0640); err != nil {
+		return time.Time{}, errors.WithMessage(err, "config: failed to persist log file open time")
+	}
+
+	return now, nil
+}
+
+func (w *rotatingFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.shouldRotate() && w.canAttemptRotate() {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+func (w *rotatingFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	signal.Stop(w.sig)
+	close(w.done)
+
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Close()
+	w.f = nil
+
+	return err
+}
+
+// canAttemptRotate reports whether enough time has passed since the last
+// rotate attempt to try again. It does not apply to a SIGHUP-forced
+// rotation, which is an explicit operator request.
+func (w *rotatingFileWriter) canAttemptRotate() bool {
+	return w.lastRotateAttempt.IsZero() || time.Since(w.lastRotateAttempt) >= rotateRetryBackoff
+}
+
+func (w *rotatingFileWriter) shouldRotate() bool {
+	if w.cfg.MaxSizeMiB > 0 && w.size >= w.cfg.MaxSizeMiB*1024*1024 {
+		return true
+	}
+	if w.cfg.MaxAgeHours > 0 && time.Since(w.opened) >= time.Duration(w.cfg.MaxAgeHours)*time.Hour {
+		return true
+	}
+
+	return false
+}
+
+// rotate closes the active log file, renames it aside (compressing it if
+// configured to do so), prunes old backups past MaxBackups, and opens a new
+// file in its place. The caller must hold w.mu.
+//
+// A failure renaming, compressing, or pruning is logged and otherwise
+// ignored rather than aborting the rotation: w.open is always attempted
+// afterwards so the writer never gets stuck pointing at a closed file handle
+// until MaxAgeHours next elapses. If w.open itself fails, w.f is left nil and,
+// since the size/age thresholds that triggered this rotation remain tripped,
+// rotation is retried on the first write once rotateRetryBackoff has passed;
+// writes made before then fail.
+func (w *rotatingFileWriter) rotate() error {
+	w.lastRotateAttempt = time.Now()
+
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			log.WithField("error", err).Warn("config: failed to close log file for rotation")
+		}
+		w.f = nil
+	}
+
+	if _, err := os.Stat(w.cfg.Path); err == nil {
+		ext := filepath.Ext(w.cfg.Path)
+		dest := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(w.cfg.Path, ext), time.Now().Format("20060102-150405"), ext)
+		if err := os.Rename(w.cfg.Path, dest); err != nil {
+			log.WithField("error", err).Warn("config: failed to rename log file for rotation, will retry")
+		} else if w.cfg.Compress {
+			if err := gzipFile(dest); err != nil {
+				log.WithField("error", err).Warn("config: failed to compress rotated log file")
+			}
+		}
+	}
+
+	if err := w.pruneBackups(); err != nil {
+		log.WithField("error", err).Warn("config: failed to prune old log files")
+	}
+
+	return w.open()
+}
+
+// pruneBackups removes the oldest rotated log files once there are more of
+// them on disk than cfg.MaxBackups allows.
+func (w *rotatingFileWriter) pruneBackups() error {
+	if w.cfg.MaxBackups <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(w.cfg.Path)
+	base := strings.TrimSuffix(filepath.Base(w.cfg.Path), filepath.Ext(w.cfg.Path))
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.WithMessage(err, "config: failed to list log directory")
+	}
+
+	var backups []os.FileInfo
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == filepath.Base(w.cfg.Path) {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), base+"-") {
+			backups = append(backups, e)
+		}
+	}
+	if len(backups) <= w.cfg.MaxBackups {
+		return nil
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime().After(backups[j].ModTime())
+	})
+
+	for _, e := range backups[w.cfg.MaxBackups:] {
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			log.WithField("error", err).WithField("file", e.Name()).Warn("config: failed to prune old log file")
+		}
+	}
+
+	return nil
+}
+
+// gzipFile compresses path in place, removing the uncompressed file once the
+// ".gz" copy has been written successfully.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/text"
+	"github.com/spf13/viper"
+)
+
+// Logging controls the format and verbosity of wings' own log output. It is
+// independent of FileLog, which only controls where that output is written
+// to on disk.
+type Logging struct {
+	// Format of emitted log lines, either "text" for wings' existing human
+	// readable format, or "json" for newline-delimited JSON with stable field
+	// names (ts, level, subsystem, msg, plus any additional fields attached
+	// to the log entry). JSON output can be shipped into something like
+	// Loki or an ELK stack without regex parsing.
+	Format string `default:"text" mapstructre:"format"`
+
+	// Level is the minimum level that will be logged for any subsystem that
+	// is not explicitly listed in Subsystems.
+	Level string `default:"info" mapstructre:"level"`
+
+	// Subsystems overrides the minimum log level for an individual part of
+	// wings, keyed by subsystem name, e.g. "server", "sftp", "router",
+	// "backups" or "transfers". This allows operators to silence a noisy
+	// subsystem, such as dropping "sftp" down to "warn", without silencing
+	// everything else.
+	Subsystems map[string]string `mapstructre:"subsystems"`
+}
+
+// LoggerFor returns a logger with its "subsystem" field set to name, e.g.
+// config.LoggerFor("sftp"). The handler installed by ConfigureLogging
+// consults this field to apply the matching Logging.Subsystems level
+// override, and includes it in JSON output so log lines can be filtered by
+// subsystem downstream.
+func LoggerFor(name string) *log.Entry {
+	return log.WithField("subsystem", name)
+}
+
+// subsystemHandler wraps an apex log.Handler so that log entries can be
+// dropped per-subsystem according to Logging.Subsystems before they ever
+// reach the underlying text or JSON handler.
+type subsystemHandler struct {
+	cfg   Logging
+	inner log.Handler
+}
+
+// newSubsystemHandler builds the dispatching handler that should be
+// installed with log.SetHandler. It writes to w using either the text
+// handler or newJSONHandler, depending on cfg.Format.
+func newSubsystemHandler(cfg Logging, w io.Writer) log.Handler {
+	var inner log.Handler
+	if strings.EqualFold(cfg.Format, "json") {
+		inner = newJSONHandler(w)
+	} else {
+		inner = text.New(w)
+	}
+
+	return &subsystemHandler{cfg: cfg, inner: inner}
+}
+
+// jsonHandler is an apex log.Handler that writes newline-delimited JSON with
+// a stable schema: "ts", "level", "subsystem" and "msg", plus any other
+// fields attached to the entry. This is deliberately not apex's stock
+// handlers/json, which marshals its own log.Entry representation
+// (timestamp/message/fields, with all attached fields nested under
+// "fields") — operators shipping wings logs into Loki/ELK need the field
+// names above at the top level to avoid writing entry-specific parsing
+// rules downstream.
+type jsonHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newJSONHandler(w io.Writer) log.Handler {
+	return &jsonHandler{w: w}
+}
+
+func (h *jsonHandler) HandleLog(e *log.Entry) error {
+	line := make(map[string]interface{}, len(e.Fields)+3)
+	for k, v := range e.Fields {
+		// encoding/json marshals an error to "{}" since it has no exported
+		// fields, which would silently drop the actual error message from
+		// the dominant "log.WithField(\"error\", err)" idiom used throughout
+		// wings. Stringify it instead so it survives into the JSON line.
+		if err, ok := v.(error); ok {
+			line[k] = err.Error()
+		} else {
+			line[k] = v
+		}
+	}
+	line["ts"] = e.Timestamp.UTC().Format(time.RFC3339Nano)
+	line["level"] = e.Level.String()
+	line["msg"] = e.Message
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.w.Write(b)
+
+	return err
+}
+
+// HandleLog drops the entry if its level is below the level configured for
+// its subsystem (or the default Logging.Level, when the entry has no
+// subsystem or no override is configured for it), otherwise it forwards the
+// entry to the wrapped text/JSON handler.
+func (h *subsystemHandler) HandleLog(e *log.Entry) error {
+	if e.Level < h.levelFor(e) {
+		return nil
+	}
+
+	return h.inner.HandleLog(e)
+}
+
+func (h *subsystemHandler) levelFor(e *log.Entry) log.Level {
+	name := h.cfg.Level
+	if sub, ok := e.Fields.Get("subsystem").(string); ok {
+		if override, ok := h.cfg.Subsystems[sub]; ok {
+			name = override
+		}
+	}
+
+	lvl, err := log.ParseLevel(name)
+	if err != nil {
+		return log.InfoLevel
+	}
+
+	return lvl
+}
+
+// fileLogWriter tracks the file writer (if any) installed by the most
+// recent call to ConfigureLogging, so a later call (e.g. on config reload)
+// can close it down before replacing it rather than leaking its goroutine
+// and open file handle.
+var fileLogWriter io.WriteCloser
+
+// ConfigureLogging installs the apex log handler wings uses for the
+// remainder of its runtime, built from the Logging and FileLog configuration
+// blocks. It replaces the single, fixed-format handler wings used
+// previously with one that supports JSON output and per-subsystem level
+// overrides, and writes to the file configured by FileLog when enabled.
+func ConfigureLogging(logging Logging, fileLog FileLog) error {
+	var w io.Writer = os.Stderr
+	var next io.WriteCloser
+
+	if fileLog.Enabled {
+		if fileLog.Path == "" {
+			fileLog.Path = filepath.Join(viper.GetString("system.log_directory"), "wings.log")
+		}
+
+		fw, err := NewFileLogWriter(fileLog)
+		if err != nil {
+			return err
+		}
+		w = fw
+		next = fw
+	}
+
+	// Only close out the previous file writer (if any) once the replacement
+	// has opened successfully, so a bad reconfigure leaves the existing,
+	// working handler and writer in place instead of leaving wings with no
+	// usable log output at all.
+	if fileLogWriter != nil {
+		if err := fileLogWriter.Close(); err != nil {
+			log.WithField("error", err).Warn("config: failed to close previous log file writer")
+		}
+	}
+	fileLogWriter = next
+
+	// apex's global logger drops any entry below log.Level before the
+	// handler is ever invoked, which would make subsystemHandler's own,
+	// finer-grained level checks unreachable for anything more verbose than
+	// the default info level. All level filtering now happens inside
+	// subsystemHandler, so the global gate is opened all the way to debug.
+	log.SetLevel(log.DebugLevel)
+	log.SetHandler(newSubsystemHandler(logging, w))
+
+	return nil
+}
@@ -78,6 +78,10 @@ type SystemConfiguration struct {
 
 	CrashDetection CrashDetection `mapstructre:"crash_detection"`
 
+	FileLog FileLog `mapstructre:"file_log"`
+
+	Logging Logging `mapstructre:"logging"`
+
 	Backups Backups `mapstructre:"backups"`
 
 	Transfers Transfers `mapstructre:"transfers"`
@@ -174,6 +178,15 @@ func EnableLogRotation() error {
 		return nil
 	}
 
+	// Wings is writing and rotating its own log file in this case, so the OS-level
+	// logrotate configuration would either be redundant or fight with wings over the
+	// same file. See FileLog for the equivalent, in-process implementation of this
+	// behavior.
+	if viper.GetBool("system.file_log.enabled") {
+		log.Info("skipping log rotate configuration, system.file_log.enabled is set")
+		return nil
+	}
+
 	if st, err := os.Stat("/etc/logrotate.d"); err != nil && !os.IsNotExist(err) {
 		return err
 	} else if (err != nil && os.IsNotExist(err)) || !st.IsDir() {